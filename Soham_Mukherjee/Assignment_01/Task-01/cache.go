@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheEntry is what both the disk cache and the in-process LRU store for
+// a single (endpoint, params) request.
+type cacheEntry struct {
+	Body         json.RawMessage `json:"body"`
+	FetchedAt    time.Time       `json:"fetched_at"`
+	ETag         string          `json:"etag,omitempty"`
+	LastModified string          `json:"last_modified,omitempty"`
+}
+
+func cacheKey(endpoint string, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(endpoint)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "|%s=%s", k, params[k])
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// diskCache persists cacheEntry values under ~/.cache/duckduckgo-go/.
+type diskCache struct {
+	dir string
+}
+
+func newDiskCache() *diskCache {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	dir := filepath.Join(home, ".cache", "duckduckgo-go")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("cache dir %s: %v", dir, err)
+	}
+	return &diskCache{dir: dir}
+}
+
+func (c *diskCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+func (c *diskCache) load(key string) (*cacheEntry, bool) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, false
+	}
+	return &e, true
+}
+
+func (c *diskCache) store(key string, e *cacheEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(c.path(key), b, 0o644); err != nil {
+		log.Printf("cache write %s: %v", key, err)
+	}
+}
+
+func (c *diskCache) clear() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.Remove(filepath.Join(c.dir, e.Name())); err != nil {
+			log.Printf("cache clear %s: %v", e.Name(), err)
+		}
+	}
+	return nil
+}
+
+// evictOlderThan drops on-disk entries last fetched before the cutoff.
+func (c *diskCache) evictOlderThan(maxAge time.Duration) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-maxAge)
+	for _, fi := range entries {
+		key := strings.TrimSuffix(fi.Name(), ".json")
+		e, ok := c.load(key)
+		if !ok {
+			continue
+		}
+		if e.FetchedAt.Before(cutoff) {
+			os.Remove(c.path(key))
+		}
+	}
+}
+
+// startEvictionLoop runs a background janitor that prunes entries older
+// than maxAge once an hour for the life of the process.
+func startEvictionLoop(c *diskCache, maxAge time.Duration) {
+	go func() {
+		for range time.Tick(time.Hour) {
+			c.evictOlderThan(maxAge)
+		}
+	}()
+}
+
+// memCache is a small RWMutex-guarded LRU sitting in front of the disk
+// cache.
+type memCache struct {
+	mu       sync.RWMutex
+	order    []string
+	entries  map[string]*cacheEntry
+	capacity int
+}
+
+func newMemCache(capacity int) *memCache {
+	return &memCache{entries: map[string]*cacheEntry{}, capacity: capacity}
+}
+
+func (m *memCache) get(key string) (*cacheEntry, bool) {
+	m.mu.RLock()
+	e, ok := m.entries[key]
+	m.mu.RUnlock()
+	if ok {
+		m.touch(key)
+	}
+	return e, ok
+}
+
+func (m *memCache) touch(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, k := range m.order {
+		if k == key {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+	m.order = append(m.order, key)
+}
+
+func (m *memCache) set(key string, e *cacheEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.entries[key]; !exists {
+		m.order = append(m.order, key)
+	}
+	m.entries[key] = e
+	for len(m.order) > m.capacity {
+		oldest := m.order[0]
+		m.order = m.order[1:]
+		delete(m.entries, oldest)
+	}
+}
+
+var (
+	diskC = newDiskCache()
+	memC  = newMemCache(256)
+)
@@ -1,10 +1,12 @@
-//  running instructions: go run duckduckgo.go Narendra Modi := search_Results for Narendra Modi
+//  running instructions: go run . Narendra Modi := search_Results for Narendra Modi
 
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
@@ -13,6 +15,18 @@ import (
 	"time"
 )
 
+// Options bundles the query-time knobs threaded through Search() and its
+// providers; it grew out of the original (providerName, safe, lang) params
+// once the cache flags and result count joined them.
+type Options struct {
+	N        int
+	Provider string
+	Safe     string
+	Lang     string
+	TTL      time.Duration
+	NoCache  bool
+}
+
 var ddgURL = "https://api.duckduckgo.com/"
 
 type NetFail struct {
@@ -23,195 +37,411 @@ func (e NetFail) Error() string {
 	return fmt.Sprintf("netfail: %v", e.Err)
 }
 
-func httpGetJSON(endpoint string, params map[string]string, retries int, timeout time.Duration) (map[string]interface{}, error) {
+// maxRetryAfterHonors caps how many times httpGetJSON will sit out a
+// Retry-After without it counting against retries. Past the cap, a
+// 429/503 falls through to the normal retry accounting below, so a server
+// that always answers with Retry-After can't spin the loop past the
+// context deadline.
+const maxRetryAfterHonors = 5
+
+func httpGetJSON(ctx context.Context, endpoint string, params map[string]string, retries int, timeout time.Duration, ttl time.Duration, useCache bool, lang string) ([]byte, error) {
+	key := cacheKey(endpoint, params)
+	var cached *cacheEntry
+	if useCache {
+		if e, ok := memC.get(key); ok {
+			cached = e
+		} else if e, ok := diskC.load(key); ok {
+			cached = e
+			memC.set(key, e)
+		}
+		if cached != nil && time.Since(cached.FetchedAt) < ttl {
+			log.Printf("upstream request endpoint=%s cache_hit=true retries=0 status=0 latency=0s", endpoint)
+			upstreamStatsFrom(ctx).record("true", 0, 0)
+			return cached.Body, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	release, err := acquireUpstream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	start := time.Now()
 	var last error
-	client := &http.Client{Timeout: timeout}
+	client := newHTTPClient(lang)
 	values := url.Values{}
 	for k, v := range params {
 		values.Set(k, v)
 	}
 	fullURL := endpoint + "?" + values.Encode()
+	attempt := 0
+	retryAfterHonors := 0
 	for i := 0; i < retries; i++ {
-		resp, err := client.Get(fullURL)
+		attempt++
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if useCache && cached != nil {
+			if cached.ETag != "" {
+				req.Header.Set("If-None-Match", cached.ETag)
+			}
+			if cached.LastModified != "" {
+				req.Header.Set("If-Modified-Since", cached.LastModified)
+			}
+		}
+		resp, err := client.Do(req)
 		if err != nil {
 			last = err
 			log.Printf("retry %d/%d: %v", i+1, retries, err)
-			time.Sleep(time.Duration(1<<i)*time.Second + time.Millisecond*500)
+			if !sleepCtx(ctx, backoffDelay(i)) {
+				break
+			}
 			continue
 		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok && retryAfterHonors < maxRetryAfterHonors {
+				retryAfterHonors++
+				log.Printf("upstream %d, honoring Retry-After %s (%d/%d)", resp.StatusCode, d, retryAfterHonors, maxRetryAfterHonors)
+				resp.Body.Close()
+				if !sleepCtx(ctx, d) {
+					break
+				}
+				i--
+				continue
+			}
+		}
 		defer resp.Body.Close()
-		if resp.StatusCode >= 500 {
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
 			last = fmt.Errorf("bad upstream %d", resp.StatusCode)
 			log.Printf("retry %d/%d: %v", i+1, retries, last)
-			time.Sleep(time.Duration(1<<i)*time.Second + time.Millisecond*500)
+			if !sleepCtx(ctx, backoffDelay(i)) {
+				break
+			}
 			continue
 		}
-		var result map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		if resp.StatusCode == http.StatusNotModified && cached != nil {
+			// cached is the *cacheEntry the mem/disk caches still hold, and a
+			// concurrent request for the same key may be holding the same
+			// pointer (memCache.get hands it out directly). Revalidate onto a
+			// copy instead of mutating cached in place.
+			revalidated := *cached
+			revalidated.FetchedAt = time.Now()
+			if useCache {
+				diskC.store(key, &revalidated)
+				memC.set(key, &revalidated)
+			}
+			log.Printf("upstream request endpoint=%s cache_hit=revalidated retries=%d status=%d latency=%s", endpoint, attempt-1, resp.StatusCode, time.Since(start))
+			upstreamStatsFrom(ctx).record("revalidated", attempt-1, resp.StatusCode)
+			return revalidated.Body, nil
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
 			last = err
 			log.Printf("retry %d/%d: %v", i+1, retries, err)
-			time.Sleep(time.Duration(1<<i)*time.Second + time.Millisecond*500)
+			if !sleepCtx(ctx, backoffDelay(i)) {
+				break
+			}
+			continue
+		}
+		if !json.Valid(body) {
+			last = fmt.Errorf("invalid json from upstream")
+			log.Printf("retry %d/%d: %v", i+1, retries, last)
+			if !sleepCtx(ctx, backoffDelay(i)) {
+				break
+			}
 			continue
 		}
-		return result, nil
+		if useCache {
+			entry := &cacheEntry{
+				Body:         body,
+				FetchedAt:    time.Now(),
+				ETag:         resp.Header.Get("ETag"),
+				LastModified: resp.Header.Get("Last-Modified"),
+			}
+			diskC.store(key, entry)
+			memC.set(key, entry)
+		}
+		log.Printf("upstream request endpoint=%s cache_hit=false retries=%d status=%d latency=%s", endpoint, attempt-1, resp.StatusCode, time.Since(start))
+		upstreamStatsFrom(ctx).record("false", attempt-1, resp.StatusCode)
+		return body, nil
+	}
+	if last == nil {
+		last = ctx.Err()
 	}
 	return nil, NetFail{last}
 }
 
-func ddgParams(q string) map[string]string {
-	return map[string]string{
+// sleepCtx sleeps for d, or returns false early if ctx is canceled first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func ddgParams(q, safe, lang string) map[string]string {
+	p := map[string]string{
 		"q":             q,
 		"format":        "json",
 		"no_html":       "1",
 		"skip_disambig": "1",
 		"t":             "agent",
 	}
+	if kl := langToKL(lang); kl != "" {
+		p["kl"] = kl
+	}
+	if kp := safeToKP(safe); kp != "" {
+		p["kp"] = kp
+	}
+	return p
 }
 
-func ddg(q string) (map[string]interface{}, error) {
-	return httpGetJSON(ddgURL, ddgParams(q), 3, 8*time.Second)
+// langToKLCountries lists the country halves DDG actually recognizes in a
+// kl=<country>-<lang> value, so a caller that already passes a real kl code
+// (e.g. "in-en") is told apart from a bare language tag (e.g. "en-in") that
+// merely looks similar but has the halves swapped.
+var langToKLCountries = map[string]bool{
+	"us": true, "uk": true, "in": true, "ca": true, "au": true,
+	"fr": true, "de": true, "es": true, "mx": true, "it": true,
+	"pt": true, "br": true, "nl": true, "ru": true, "jp": true,
+	"cn": true, "tw": true, "kr": true, "se": true, "no": true,
+	"dk": true, "fi": true, "pl": true, "tr": true, "xa": true,
+	"wt": true,
 }
 
-func flatten(rt []interface{}) []map[string]string {
-	out := []map[string]string{}
-	for _, item := range rt {
-		m, ok := item.(map[string]interface{})
-		if !ok {
-			continue
-		}
-		if topics, ok := m["Topics"].([]interface{}); ok {
-			for _, t := range topics {
-				topic, ok := t.(map[string]interface{})
-				if ok && topic["FirstURL"] != nil && topic["Text"] != nil {
-					out = append(out, map[string]string{
-						"title": topic["Text"].(string),
-						"url":   topic["FirstURL"].(string),
-					})
-				}
-			}
-		} else {
-			if m["FirstURL"] != nil && m["Text"] != nil {
-				out = append(out, map[string]string{
-					"title": m["Text"].(string),
-					"url":   m["FirstURL"].(string),
-				})
-			}
-		}
-	}
-	seen := map[string]bool{}
-	dedup := []map[string]string{}
-	for _, x := range out {
-		if !seen[x["url"]] {
-			seen[x["url"]] = true
-			dedup = append(dedup, x)
-		}
-	}
-	return dedup
+// langToKLTable maps common --lang values (bare language codes and the
+// usual language-region tags) onto the kl code DDG expects for them.
+var langToKLTable = map[string]string{
+	"en":    "us-en",
+	"en-us": "us-en",
+	"en-gb": "uk-en",
+	"en-au": "au-en",
+	"en-ca": "ca-en",
+	"en-in": "in-en",
+	"fr":    "fr-fr",
+	"fr-ca": "ca-fr",
+	"de":    "de-de",
+	"es":    "es-es",
+	"es-mx": "mx-es",
+	"it":    "it-it",
+	"pt":    "pt-pt",
+	"pt-br": "br-pt",
+	"nl":    "nl-nl",
+	"ru":    "ru-ru",
+	"ja":    "jp-jp",
+	"zh":    "cn-zh",
+	"zh-tw": "tw-tzh",
+	"ko":    "kr-ko",
+	"ar":    "xa-ar",
+	"hi":    "in-en",
+	"sv":    "se-sv",
+	"no":    "no-no",
+	"da":    "dk-da",
+	"fi":    "fi-fi",
+	"pl":    "pl-pl",
+	"tr":    "tr-tr",
 }
 
-func pick(d map[string]interface{}) map[string]string {
-	if v, ok := d["Answer"].(string); ok && v != "" {
-		return map[string]string{"kind": "answer", "value": v}
+// looksLikeKL reports whether s is already a real kl code, i.e.
+// "<country>-<lang>" with a country half DDG recognizes. This is what
+// keeps a swapped tag like "en-us" from being passed through unmapped.
+func looksLikeKL(s string) bool {
+	country, _, ok := strings.Cut(s, "-")
+	return ok && langToKLCountries[country]
+}
+
+// langToKL maps a --lang value onto DDG's kl region param: a code that's
+// already a real kl value (e.g. "in-en") passes through untouched, a known
+// language/region tag (e.g. "en", "pt-br") is looked up in langToKLTable,
+// and anything else is dropped (logged, kl omitted) rather than sent on as
+// an invalid region DDG would silently ignore.
+func langToKL(lang string) string {
+	norm := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+	if norm == "" {
+		return ""
 	}
-	if v, ok := d["Definition"].(string); ok && v != "" {
-		return map[string]string{"kind": "definition", "value": v}
+	if kl, ok := langToKLTable[norm]; ok {
+		return kl
 	}
-	if v, ok := d["AbstractText"].(string); ok && v != "" {
-		return map[string]string{"kind": "abstract", "value": v}
+	if looksLikeKL(norm) {
+		return norm
 	}
-	if h, ok := d["Heading"].(string); ok && h != "" {
-		if a, ok := d["Abstract"].(string); ok && a != "" {
-			return map[string]string{"kind": "abstract", "value": a}
-		}
+	log.Printf("lang %q has no known kl mapping, omitting region", lang)
+	return ""
+}
+
+// safeToKP maps --safe off|moderate|strict onto DDG's kp safe-search param.
+func safeToKP(safe string) string {
+	switch safe {
+	case "off":
+		return "-2"
+	case "strict":
+		return "1"
+	case "moderate", "":
+		return "-1"
+	default:
+		return ""
 	}
-	return nil
 }
 
-func search(q string, want int) map[string]interface{} {
-	log.Printf("searching: %s", q)
-	raw, err := ddg(q)
+func ddg(ctx context.Context, q string, opts Options) (*DDGResponse, error) {
+	body, err := httpGetJSON(ctx, ddgURL, ddgParams(q, opts.Safe, opts.Lang), 3, 8*time.Second, opts.TTL, !opts.NoCache, opts.Lang)
 	if err != nil {
-		log.Printf("ddg err: %v", err)
-		raw = map[string]interface{}{}
+		return nil, err
 	}
-	var related []map[string]string
-	if rt, ok := raw["RelatedTopics"].([]interface{}); ok {
-		related = flatten(rt)
-	}
-	ans := pick(raw)
-	if ans != nil {
-		return map[string]interface{}{
-			"query":   q,
-			"mode":    "instant",
-			"answer":  ans,
-			"related": related[:min(want, len(related))],
-		}
-	}
-	if len(related) > 0 {
-		return map[string]interface{}{
-			"query":   q,
-			"mode":    "related_only",
-			"related": related[:min(want, len(related))],
-			"hint":    "try broader search",
-		}
-	}
-	return map[string]interface{}{
-		"query":   q,
-		"mode":    "dry",
-		"related": related[:min(want, len(related))],
-		"hint":    "nothing solid",
+	var resp DDGResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
 	}
+	return &resp, nil
 }
 
-func asText(r map[string]interface{}) string {
-	lines := []string{}
-	if ans, ok := r["answer"].(map[string]string); ok {
-		lines = append(lines, fmt.Sprintf("%s: %s", ans["kind"], ans["value"]))
+// Search runs q through the providers selected by opts.Provider ("ddg-api",
+// "ddg-html" or "all"), merging and deduping their results. When the instant
+// API comes back dry (no answer, no related topics) it transparently falls
+// back to the html provider regardless of what was requested.
+func Search(ctx context.Context, q string, opts Options) (*SearchResult, error) {
+	log.Printf("searching: %s (provider=%s)", q, opts.Provider)
+	want := opts.N
+	if want <= 0 {
+		want = 6
 	}
-	if related, ok := r["related"].([]map[string]string); ok && len(related) > 0 {
-		lines = append(lines, "related:")
-		for _, x := range related {
-			lines = append(lines, fmt.Sprintf("- %s -> %s", x["title"], x["url"]))
+	providers := providersFor(opts)
+
+	var ans *InstantAnswer
+	var infobox []InfoboxField
+	var related []Result
+	seen := map[string]bool{}
+	merge := func(p Provider) {
+		results, err := p.Search(ctx, q, want)
+		if err != nil {
+			log.Printf("%s err: %v", p.Name(), err)
+			return
+		}
+		if ip, ok := p.(*instantProvider); ok {
+			ans = ip.answer
+			infobox = ip.infobox
+		}
+		for _, r := range results {
+			if seen[r.URL] {
+				continue
+			}
+			seen[r.URL] = true
+			related = append(related, r)
+			if len(related) >= want {
+				break
+			}
 		}
 	}
-	if hint, ok := r["hint"].(string); ok && hint != "" {
-		lines = append(lines, hint)
+	for _, p := range providers {
+		merge(p)
 	}
-	return strings.Join(lines, "\n")
-}
 
-func min(a, b int) int {
-	if a < b {
-		return a
+	if ans == nil && len(related) == 0 && opts.Provider != "ddg-html" && opts.Provider != "all" {
+		log.Printf("instant API dry, falling back to html scrape")
+		merge(&htmlProvider{safe: opts.Safe, lang: opts.Lang})
 	}
-	return b
+
+	r := &SearchResult{Query: q, Related: related, Infobox: infobox}
+	switch {
+	case ans != nil:
+		r.Mode, r.Answer = "instant", ans
+	case len(related) > 0:
+		r.Mode, r.Hint = "related_only", "try broader search"
+	default:
+		r.Mode, r.Hint = "dry", "nothing solid"
+	}
+	return r, nil
 }
 
 func main() {
 	if len(os.Args) < 2 {
-		fmt.Println("usage: duckduckgo <query> [--json] [--n N]")
+		fmt.Println("usage: duckduckgo <query> [--json] [--n N] [--provider ddg-api|ddg-html|all] [--safe off|moderate|strict] [--lang xx] [--ttl 24h] [--no-cache] [--cache-clear] [--max-age 168h] [--max-concurrency N]")
+		fmt.Println("       duckduckgo --serve :8080 [--provider ...] [--safe ...] [--lang ...] [--max-concurrency N]")
 		os.Exit(1)
 	}
 	args := os.Args[1:]
 	asJSON := false
-	want := 6
+	opts := Options{N: 6, Provider: "ddg-api", Safe: "moderate", TTL: 24 * time.Hour}
+	maxAge := 7 * 24 * time.Hour
+	cacheClear := false
+	serveAddr := ""
+	maxConcurrency := 4
 	queryParts := []string{}
 	for i := 0; i < len(args); i++ {
-		if args[i] == "--json" {
+		switch {
+		case args[i] == "--json":
 			asJSON = true
-		} else if args[i] == "--n" && i+1 < len(args) {
-			fmt.Sscanf(args[i+1], "%d", &want)
+		case args[i] == "--n" && i+1 < len(args):
+			fmt.Sscanf(args[i+1], "%d", &opts.N)
+			i++
+		case args[i] == "--provider" && i+1 < len(args):
+			opts.Provider = args[i+1]
+			i++
+		case args[i] == "--safe" && i+1 < len(args):
+			opts.Safe = args[i+1]
+			i++
+		case args[i] == "--lang" && i+1 < len(args):
+			opts.Lang = args[i+1]
+			i++
+		case args[i] == "--ttl" && i+1 < len(args):
+			if d, err := time.ParseDuration(args[i+1]); err == nil {
+				opts.TTL = d
+			}
+			i++
+		case args[i] == "--max-age" && i+1 < len(args):
+			if d, err := time.ParseDuration(args[i+1]); err == nil {
+				maxAge = d
+			}
+			i++
+		case args[i] == "--no-cache":
+			opts.NoCache = true
+		case args[i] == "--cache-clear":
+			cacheClear = true
+		case args[i] == "--serve" && i+1 < len(args):
+			serveAddr = args[i+1]
+			i++
+		case args[i] == "--max-concurrency" && i+1 < len(args):
+			fmt.Sscanf(args[i+1], "%d", &maxConcurrency)
 			i++
-		} else {
+		default:
 			queryParts = append(queryParts, args[i])
 		}
 	}
+
+	if cacheClear {
+		if err := diskC.clear(); err != nil {
+			log.Printf("cache clear: %v", err)
+		}
+	}
+	startEvictionLoop(diskC, maxAge)
+	initUpstreamSem(maxConcurrency)
+
+	if serveAddr != "" {
+		if err := runServer(serveAddr, opts); err != nil {
+			log.Fatalf("serve: %v", err)
+		}
+		return
+	}
+
 	q := strings.Join(queryParts, " ")
-	r := search(q, want)
+	if q == "" {
+		return
+	}
+	r, err := Search(context.Background(), q, opts)
+	if err != nil {
+		log.Fatalf("search: %v", err)
+	}
 	if asJSON {
-		b, _ := json.MarshalIndent(r, "", "  ")
+		b, _ := r.JSON()
 		fmt.Println(string(b))
 	} else {
-		fmt.Println(asText(r))
+		fmt.Println(r.Text())
 	}
 }
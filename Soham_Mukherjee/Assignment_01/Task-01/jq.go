@@ -0,0 +1,65 @@
+package main
+
+import "encoding/json"
+
+// JQ is a tiny read-only accessor over a decoded JSON value, for the
+// handful of places DDG's schema is genuinely dynamic (grouped vs. flat
+// RelatedTopics, optional Infobox.content rows, nested Icon objects) where
+// a typed struct would just be a pile of optional fields.
+type JQ struct {
+	v interface{}
+}
+
+// jq decodes raw JSON into a JQ accessor. A decode failure leaves it
+// pointing at nil, so later String/Array calls just return zero values.
+func jq(raw json.RawMessage) JQ {
+	if len(raw) == 0 {
+		return JQ{}
+	}
+	var v interface{}
+	json.Unmarshal(raw, &v)
+	return JQ{v: v}
+}
+
+// jqFrom wraps an already-decoded value, for walking into a step produced
+// by a previous Array() call.
+func jqFrom(v interface{}) JQ {
+	return JQ{v: v}
+}
+
+// get walks path through the wrapped value, where a string element
+// indexes an object and an int element indexes an array.
+func (j JQ) get(path ...interface{}) interface{} {
+	cur := j.v
+	for _, p := range path {
+		switch key := p.(type) {
+		case string:
+			m, ok := cur.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			cur = m[key]
+		case int:
+			arr, ok := cur.([]interface{})
+			if !ok || key < 0 || key >= len(arr) {
+				return nil
+			}
+			cur = arr[key]
+		default:
+			return nil
+		}
+	}
+	return cur
+}
+
+// String reads path as a string, returning "" if it's absent or not a string.
+func (j JQ) String(path ...interface{}) string {
+	s, _ := j.get(path...).(string)
+	return s
+}
+
+// Array reads path as a JSON array.
+func (j JQ) Array(path ...interface{}) []interface{} {
+	arr, _ := j.get(path...).([]interface{})
+	return arr
+}
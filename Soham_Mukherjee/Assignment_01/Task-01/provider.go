@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Result is a single hit, whichever provider produced it.
+type Result struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Snippet string `json:"snippet,omitempty"`
+	Icon    string `json:"icon,omitempty"`
+}
+
+// Provider fetches up to n results for q. Implementations hit a specific
+// DDG surface (the instant answer API, the html results page, ...).
+type Provider interface {
+	Name() string
+	Search(ctx context.Context, q string, n int) ([]Result, error)
+}
+
+// providersFor builds the provider chain for an Options.Provider value,
+// falling back to ddg-api when it's unrecognized.
+func providersFor(opts Options) []Provider {
+	switch opts.Provider {
+	case "ddg-html":
+		return []Provider{&htmlProvider{safe: opts.Safe, lang: opts.Lang}}
+	case "all":
+		return []Provider{&instantProvider{opts: opts}, &htmlProvider{safe: opts.Safe, lang: opts.Lang}}
+	default:
+		return []Provider{&instantProvider{opts: opts}}
+	}
+}
+
+// instantProvider wraps the existing Instant Answer API. Search also
+// stashes the short-form answer and infobox rows (if any) since those
+// aren't "related" results but Search() still wants to surface them.
+type instantProvider struct {
+	opts    Options
+	answer  *InstantAnswer
+	infobox []InfoboxField
+}
+
+func (p *instantProvider) Name() string { return "ddg-api" }
+
+func (p *instantProvider) Search(ctx context.Context, q string, n int) ([]Result, error) {
+	resp, err := ddg(ctx, q, p.opts)
+	if err != nil {
+		return nil, err
+	}
+	p.answer = pickAnswer(*resp)
+	p.infobox = decodeInfobox(resp.Infobox)
+
+	var related []Result
+	for _, t := range decodeRelatedTopics(resp.RelatedTopics) {
+		related = append(related, Result{Title: t.Title, URL: t.URL})
+	}
+	related = append(related, decodeResults(resp.Results)...)
+	if n > 0 && n < len(related) {
+		related = related[:n]
+	}
+	return related, nil
+}
+
+// htmlProvider scrapes the plain html results page, used when the instant
+// API comes back dry or when the caller explicitly asks for it.
+type htmlProvider struct {
+	safe string
+	lang string
+}
+
+var htmlURL = "https://duckduckgo.com/html/"
+
+func (p *htmlProvider) Name() string { return "ddg-html" }
+
+func (p *htmlProvider) Search(ctx context.Context, q string, n int) ([]Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, 8*time.Second)
+	defer cancel()
+	release, err := acquireUpstream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	values := url.Values{}
+	values.Set("q", q)
+	if kl := langToKL(p.lang); kl != "" {
+		values.Set("kl", kl)
+	}
+	if kp := safeToKP(p.safe); kp != "" {
+		values.Set("kp", kp)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, htmlURL+"?"+values.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := newHTTPClient(p.lang).Do(req)
+	if err != nil {
+		return nil, NetFail{err}
+	}
+	defer resp.Body.Close()
+	upstreamStatsFrom(ctx).record("false", 0, resp.StatusCode)
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var out []Result
+	doc.Find(".result").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if n > 0 && len(out) >= n {
+			return false
+		}
+		a := s.Find(".result__a").First()
+		title := strings.TrimSpace(a.Text())
+		href, _ := s.Find(".result__url").First().Attr("href")
+		if href == "" {
+			href, _ = a.Attr("href")
+		}
+		target := unwrapDDGRedirect(href)
+		if title == "" || target == "" {
+			return true
+		}
+		out = append(out, Result{
+			Title:   title,
+			URL:     target,
+			Snippet: strings.TrimSpace(s.Find(".result__snippet").First().Text()),
+		})
+		return true
+	})
+	return out, nil
+}
+
+// unwrapDDGRedirect pulls the real destination out of DDG's /l/?uddg=...
+// result-page redirect links.
+func unwrapDDGRedirect(href string) string {
+	u, err := url.Parse(href)
+	if err != nil {
+		return href
+	}
+	if uddg := u.Query().Get("uddg"); uddg != "" {
+		if decoded, err := url.QueryUnescape(uddg); err == nil {
+			return decoded
+		}
+	}
+	if strings.HasPrefix(href, "//") {
+		return "https:" + href
+	}
+	return href
+}
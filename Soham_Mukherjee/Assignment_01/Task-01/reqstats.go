@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// upstreamStats collects the cache/retry/status facts from whichever
+// upstream call(s) a single Search serves, so a caller like handleSearch
+// can fold them into one self-contained log line instead of correlating
+// them against httpGetJSON's own per-call log lines by eye.
+type upstreamStats struct {
+	mu       sync.Mutex
+	cacheHit string
+	retries  int
+	status   int
+}
+
+func (s *upstreamStats) record(cacheHit string, retries, status int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cacheHit = cacheHit
+	s.retries = retries
+	s.status = status
+}
+
+func (s *upstreamStats) snapshot() (cacheHit string, retries, status int) {
+	if s == nil {
+		return "", 0, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cacheHit, s.retries, s.status
+}
+
+type upstreamStatsKey struct{}
+
+// withUpstreamStats attaches s to ctx so httpGetJSON (and any other
+// upstream caller) can report back into it.
+func withUpstreamStats(ctx context.Context, s *upstreamStats) context.Context {
+	return context.WithValue(ctx, upstreamStatsKey{}, s)
+}
+
+// upstreamStatsFrom returns the *upstreamStats attached to ctx, or nil if
+// none was attached (e.g. the one-shot CLI path, which just logs directly).
+func upstreamStatsFrom(ctx context.Context) *upstreamStats {
+	s, _ := ctx.Value(upstreamStatsKey{}).(*upstreamStats)
+	return s
+}
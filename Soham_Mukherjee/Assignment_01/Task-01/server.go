@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// upstreamSem bounds how many outbound DDG requests (instant API or html
+// scrape) run at once, across every served request.
+var upstreamSem chan struct{}
+
+func initUpstreamSem(n int) {
+	if n <= 0 {
+		n = 4
+	}
+	upstreamSem = make(chan struct{}, n)
+}
+
+// acquireUpstream blocks for a free slot, but gives up if ctx is canceled
+// (client disconnect or request deadline) first.
+func acquireUpstream(ctx context.Context) (func(), error) {
+	select {
+	case upstreamSem <- struct{}{}:
+		return func() { <-upstreamSem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// requestDeadline bounds how long a single /search request is allowed to
+// run, on top of whatever per-upstream-call timeout Search's providers use.
+const requestDeadline = 10 * time.Second
+
+// runServer turns the one-shot CLI into a daemon exposing /search and
+// /healthz; /search reuses Search(), /healthz just answers "ok".
+func runServer(addr string, base Options) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/search", handleSearch(base))
+	log.Printf("serving on %s", addr)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      requestDeadline + 5*time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+	return srv.ListenAndServe()
+}
+
+func handleSearch(base Options) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		q := r.URL.Query().Get("q")
+		if q == "" {
+			http.Error(w, "missing q", http.StatusBadRequest)
+			return
+		}
+		opts := base
+		if n, err := strconv.Atoi(r.URL.Query().Get("n")); err == nil && n > 0 {
+			opts.N = n
+		}
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+
+		stats := &upstreamStats{}
+		ctx, cancel := context.WithTimeout(withUpstreamStats(r.Context(), stats), requestDeadline)
+		defer cancel()
+		res, err := Search(ctx, q, opts)
+		latency := time.Since(start)
+		cacheHit, retries, status := stats.snapshot()
+		if err != nil {
+			log.Printf("served query=%q format=%s status=error cache_hit=%s retries=%d upstream_status=%d latency=%s err=%v", q, format, cacheHit, retries, status, latency, err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		log.Printf("served query=%q format=%s mode=%s related=%d cache_hit=%s retries=%d upstream_status=%d latency=%s", q, format, res.Mode, len(res.Related), cacheHit, retries, status, latency)
+
+		switch format {
+		case "text":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprintln(w, res.Text())
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			b, err := res.JSON()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write(b)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// DDGResponse mirrors the fields of the Instant Answer API response we
+// actually use. RelatedTopics/Results/Infobox stay as raw JSON because
+// their shape genuinely varies (flat topics vs. grouped topics, optional
+// Icon objects, ...) and get walked with jq instead.
+type DDGResponse struct {
+	Heading       string            `json:"Heading"`
+	Abstract      string            `json:"Abstract"`
+	AbstractText  string            `json:"AbstractText"`
+	AbstractURL   string            `json:"AbstractURL"`
+	Answer        string            `json:"Answer"`
+	Definition    string            `json:"Definition"`
+	Infobox       json.RawMessage   `json:"Infobox"`
+	RelatedTopics []json.RawMessage `json:"RelatedTopics"`
+	Results       []json.RawMessage `json:"Results"`
+}
+
+// InstantAnswer is the short-form answer/definition/abstract DDG surfaces
+// directly, picked by pickAnswer.
+type InstantAnswer struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// RelatedTopic is a single flattened entry out of DDGResponse.RelatedTopics,
+// before it's folded into the provider-agnostic Result shape.
+type RelatedTopic struct {
+	Title string
+	URL   string
+}
+
+// InfoboxField is one row of DDGResponse.Infobox.content.
+type InfoboxField struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// SearchResult is the public shape returned by Search. Field order here is
+// what json.MarshalIndent emits, so it stays stable across calls.
+type SearchResult struct {
+	Query   string         `json:"query"`
+	Mode    string         `json:"mode"`
+	Answer  *InstantAnswer `json:"answer,omitempty"`
+	Related []Result       `json:"related"`
+	Infobox []InfoboxField `json:"infobox,omitempty"`
+	Hint    string         `json:"hint,omitempty"`
+}
+
+// JSON renders the result with the same indentation main has always used.
+func (r *SearchResult) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// Text renders the result the way the CLI prints it without --json.
+func (r *SearchResult) Text() string {
+	lines := []string{}
+	if r.Answer != nil {
+		lines = append(lines, r.Answer.Kind+": "+r.Answer.Value)
+	}
+	if len(r.Related) > 0 {
+		lines = append(lines, "related:")
+		for _, x := range r.Related {
+			lines = append(lines, "- "+x.Title+" -> "+x.URL)
+		}
+	}
+	if r.Hint != "" {
+		lines = append(lines, r.Hint)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// pickAnswer picks the best short-form answer off a decoded DDG response:
+// a direct Answer, then Definition, then AbstractText/Abstract.
+func pickAnswer(d DDGResponse) *InstantAnswer {
+	if d.Answer != "" {
+		return &InstantAnswer{Kind: "answer", Value: d.Answer}
+	}
+	if d.Definition != "" {
+		return &InstantAnswer{Kind: "definition", Value: d.Definition}
+	}
+	if d.AbstractText != "" {
+		return &InstantAnswer{Kind: "abstract", Value: d.AbstractText}
+	}
+	if d.Heading != "" && d.Abstract != "" {
+		return &InstantAnswer{Kind: "abstract", Value: d.Abstract}
+	}
+	return nil
+}
+
+// decodeRelatedTopics flattens DDGResponse.RelatedTopics, which DDG nests
+// one level deeper ({"Topics": [...]}) for grouped categories.
+func decodeRelatedTopics(raw []json.RawMessage) []RelatedTopic {
+	var out []RelatedTopic
+	seen := map[string]bool{}
+	var walk func(item JQ)
+	walk = func(item JQ) {
+		if topics := item.Array("Topics"); len(topics) > 0 {
+			for _, t := range topics {
+				walk(jqFrom(t))
+			}
+			return
+		}
+		title, url := item.String("Text"), item.String("FirstURL")
+		if title == "" || url == "" || seen[url] {
+			return
+		}
+		seen[url] = true
+		out = append(out, RelatedTopic{Title: title, URL: url})
+	}
+	for _, r := range raw {
+		walk(jq(r))
+	}
+	return out
+}
+
+// decodeResults flattens DDGResponse.Results (the sponsored/result-bar
+// entries), pulling the nested Icon.URL out via jq.
+func decodeResults(raw []json.RawMessage) []Result {
+	var out []Result
+	for _, r := range raw {
+		item := jq(r)
+		title, url := item.String("Text"), item.String("FirstURL")
+		if title == "" || url == "" {
+			continue
+		}
+		out = append(out, Result{Title: title, URL: url, Icon: item.String("Icon", "URL")})
+	}
+	return out
+}
+
+// decodeInfobox pulls the label/value rows out of DDGResponse.Infobox.content.
+func decodeInfobox(raw json.RawMessage) []InfoboxField {
+	if len(raw) == 0 {
+		return nil
+	}
+	var out []InfoboxField
+	for _, c := range jq(raw).Array("content") {
+		row := jqFrom(c)
+		label := row.String("label")
+		if label == "" {
+			continue
+		}
+		out = append(out, InfoboxField{Label: label, Value: row.String("value")})
+	}
+	return out
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var caniuseURL = "https://raw.githubusercontent.com/Fyrd/caniuse/main/fulldata-json/data-2.0.json"
+
+type uaEntry struct {
+	Browser string
+	Version string
+	Usage   float64
+}
+
+// userAgentPool keeps a weighted list of real-world Firefox/Chrome
+// versions, refreshed from caniuse's usage data once per run (cached for
+// 24h behind an RWMutex, same pattern as the browserCache in EXTERNAL DOC 6).
+type userAgentPool struct {
+	mu         sync.RWMutex
+	entries    []uaEntry
+	fetchedAt  time.Time
+	refreshing bool
+}
+
+var agent = &userAgentPool{}
+
+// Random returns a User-Agent string for one of the weighted browser
+// versions. It never blocks on the network: a stale or empty pool triggers
+// a background refresh and falls back to fallbackUA (or the last good
+// pool) for this call.
+func (p *userAgentPool) Random() string {
+	p.refresh()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.entries) == 0 {
+		return fallbackUA
+	}
+	return uaString(weightedPick(p.entries))
+}
+
+// refresh kicks off a background caniuse fetch when the pool is stale and
+// nothing is already in flight. It returns immediately either way, so it's
+// safe to call from RoundTrip's hot path; the refreshing flag keeps
+// concurrent callers from stampeding caniuse with duplicate fetches.
+func (p *userAgentPool) refresh() {
+	p.mu.Lock()
+	fresh := len(p.entries) > 0 && time.Since(p.fetchedAt) < 24*time.Hour
+	if fresh || p.refreshing {
+		p.mu.Unlock()
+		return
+	}
+	p.refreshing = true
+	p.mu.Unlock()
+
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			p.refreshing = false
+			p.mu.Unlock()
+		}()
+		entries, err := fetchCaniuseUsage()
+		if err != nil {
+			log.Printf("useragent refresh: %v", err)
+			return
+		}
+		p.mu.Lock()
+		p.entries = entries
+		p.fetchedAt = time.Now()
+		p.mu.Unlock()
+	}()
+}
+
+type caniuseData struct {
+	Agents map[string]struct {
+		Browser     string             `json:"browser"`
+		UsageGlobal map[string]float64 `json:"usage_global"`
+	} `json:"agents"`
+}
+
+// fetchCaniuseUsage runs off the request hot path (see userAgentPool.refresh),
+// but still carries its own bounded timeout rather than the default client's
+// unbounded one, so a wedged caniuse fetch can't pile up goroutines forever.
+func fetchCaniuseUsage() ([]uaEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, caniuseURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, NetFail{err}
+	}
+	defer resp.Body.Close()
+	var data caniuseData
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	var out []uaEntry
+	for _, browser := range []string{"firefox", "chrome"} {
+		a, ok := data.Agents[browser]
+		if !ok {
+			continue
+		}
+		for version, usage := range a.UsageGlobal {
+			if usage <= 0 {
+				continue
+			}
+			out = append(out, uaEntry{Browser: browser, Version: version, Usage: usage})
+		}
+	}
+	return out, nil
+}
+
+// weightedPick chooses an entry with probability proportional to its
+// usage_global share.
+func weightedPick(entries []uaEntry) uaEntry {
+	var total float64
+	for _, e := range entries {
+		total += e.Usage
+	}
+	if total <= 0 {
+		return entries[rand.Intn(len(entries))]
+	}
+	r := rand.Float64() * total
+	for _, e := range entries {
+		r -= e.Usage
+		if r <= 0 {
+			return e
+		}
+	}
+	return entries[len(entries)-1]
+}
+
+const fallbackUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"
+
+func uaString(e uaEntry) string {
+	switch e.Browser {
+	case "firefox":
+		return fmt.Sprintf("Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:%s) Gecko/20100101 Firefox/%s", e.Version, e.Version)
+	case "chrome":
+		return fmt.Sprintf("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/%s Safari/537.36", e.Version)
+	default:
+		return fallbackUA
+	}
+}
+
+// uaRoundTripper stamps every outbound request with a rotating User-Agent
+// and, when set, an Accept-Language derived from --lang.
+type uaRoundTripper struct {
+	next http.RoundTripper
+	lang string
+}
+
+func (t *uaRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", agent.Random())
+	if t.lang != "" {
+		req.Header.Set("Accept-Language", t.lang)
+	}
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return next.RoundTrip(req)
+}
+
+// newHTTPClient builds a client with no Client-level timeout: callers
+// derive their deadline on the request's context instead, so a client
+// disconnect or a server-side deadline cancels in-flight calls promptly.
+func newHTTPClient(lang string) *http.Client {
+	return &http.Client{Transport: &uaRoundTripper{lang: lang}}
+}
+
+const maxBackoff = 30 * time.Second
+
+// backoffDelay returns a fully-jittered delay for the given retry attempt:
+// a random share of 2^attempt seconds (capped at maxBackoff) plus a fixed
+// 500ms, so the longest possible delay is maxBackoff+500ms.
+func backoffDelay(attempt int) time.Duration {
+	exp := time.Duration(1<<uint(attempt)) * time.Second
+	if exp <= 0 || exp > maxBackoff {
+		exp = maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(exp))) + 500*time.Millisecond
+}
+
+// parseRetryAfter understands both the delay-seconds and HTTP-date forms
+// of a Retry-After header.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	var secs int
+	if _, err := fmt.Sscanf(v, "%d", &secs); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+	}
+	return 0, false
+}